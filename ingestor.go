@@ -0,0 +1,389 @@
+// Copyright 2017 Pilosa Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilosa
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pilosa/pilosa/internal"
+)
+
+// ErrorPolicy controls how an Ingestor reacts to a record it cannot decode
+// or apply.
+type ErrorPolicy int
+
+const (
+	// SkipBadRecord drops the offending record and continues consuming.
+	SkipBadRecord ErrorPolicy = iota
+	// FailFast aborts Consume on the first error.
+	FailFast
+	// DeadLetterPolicy writes a description of the offending record (see
+	// IngestorOptions.DeadLetterWriter) to a configured io.Writer and
+	// continues consuming. Use DeadLetter to construct options using it.
+	DeadLetterPolicy
+)
+
+// DeadLetter returns IngestorOptions whose ErrorPolicy is DeadLetterPolicy
+// and whose DeadLetterWriter is w, so bad records are written to w instead of
+// being dropped or aborting Consume.
+func DeadLetter(w io.Writer) IngestorOptions {
+	return IngestorOptions{ErrorPolicy: DeadLetterPolicy, DeadLetterWriter: w}
+}
+
+// IngestorOptions configures an Ingestor.
+type IngestorOptions struct {
+	// BatchSize is the number of records buffered per shard before a flush
+	// is triggered.
+	BatchSize int
+
+	// MaxInFlightBatches caps the number of batches being flushed
+	// concurrently across all shards.
+	MaxInFlightBatches int
+
+	// FlushInterval is the maximum time a partial per-shard batch is held
+	// before being flushed regardless of size.
+	FlushInterval time.Duration
+
+	// ErrorPolicy determines what happens to a record that fails to decode
+	// or apply.
+	ErrorPolicy ErrorPolicy
+
+	// DeadLetterWriter receives a description of any record dropped under
+	// DeadLetterPolicy: the decode error for a record that failed to parse,
+	// or the record itself (re-encoded as JSON) for one that failed to
+	// apply.
+	DeadLetterWriter io.Writer
+}
+
+// Stats summarizes an Ingestor.Consume run.
+type Stats struct {
+	RecordsIn   uint64
+	BitsOut     uint64
+	DecodeErrors uint64
+}
+
+// Ingestor consumes a continuous stream of records, decoded according to its
+// InputDefinition's Format (json-lines, csv, or avro — see
+// record_decoder.go), and applies the definition's Fields/Actions to them,
+// sharding by PrimaryKey and flushing SetBit mutations through the
+// definition's Broadcaster.
+type Ingestor struct {
+	def  *InputDefinition
+	opts IngestorOptions
+
+	recordsIn    uint64
+	bitsOut      uint64
+	decodeErrors uint64
+	actionLatency sync.Map // field name -> time.Duration (last observed)
+}
+
+// NewIngestor returns an Ingestor that applies i's Fields/Actions to
+// incoming records according to opts.
+func (i *InputDefinition) NewIngestor(opts IngestorOptions) *Ingestor {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	if opts.MaxInFlightBatches <= 0 {
+		opts.MaxInFlightBatches = 4
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	return &Ingestor{def: i, opts: opts}
+}
+
+// shard buffers records for one PrimaryKey-derived shard.
+type shard struct {
+	mu      sync.Mutex
+	records []map[string]interface{}
+}
+
+// decoder returns the RecordDecoder Consume reads records through: the one
+// configured on the InputDefinition (populated by InputDefinition.Open), or
+// one derived from Format/FormatOptions directly if Open was never called.
+func (ig *Ingestor) decoder() (RecordDecoder, error) {
+	if d := ig.def.Decoder(); d != nil {
+		return d, nil
+	}
+	return newRecordDecoder(ig.def.Format(), ig.def.FormatOptions())
+}
+
+// Consume decodes records from r, using the format configured on the
+// Ingestor's InputDefinition, until EOF or ctx is canceled, applies the
+// definition's Fields/Actions to each, and flushes the resulting Bits via the
+// Broadcaster. It returns aggregate Stats once consumption stops.
+func (ig *Ingestor) Consume(ctx context.Context, r io.Reader) (Stats, error) {
+	decoder, err := ig.decoder()
+	if err != nil {
+		return ig.stats(), err
+	}
+
+	shards := make(map[uint64]*shard)
+	var shardsMu sync.Mutex
+	inFlight := make(chan struct{}, ig.opts.MaxInFlightBatches)
+	var wg sync.WaitGroup
+	var flushErr error
+	var flushErrMu sync.Mutex
+
+	flush := func(s *shard) {
+		s.mu.Lock()
+		records := s.records
+		s.records = nil
+		s.mu.Unlock()
+		if len(records) == 0 {
+			return
+		}
+
+		inFlight <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-inFlight }()
+			if err := ig.applyAndBroadcast(ctx, records); err != nil {
+				flushErrMu.Lock()
+				if flushErr == nil {
+					flushErr = err
+				}
+				flushErrMu.Unlock()
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(ig.opts.FlushInterval)
+	defer ticker.Stop()
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				shardsMu.Lock()
+				for _, s := range shards {
+					flush(s)
+				}
+				shardsMu.Unlock()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	records, decodeErrs := decoder.Decode(r, ig.def.Fields())
+	var consumeErr error
+decodeLoop:
+	for records != nil || decodeErrs != nil {
+		select {
+		case <-ctx.Done():
+			break decodeLoop
+
+		case record, ok := <-records:
+			if !ok {
+				records = nil
+				continue
+			}
+			atomic.AddUint64(&ig.recordsIn, 1)
+
+			key := ig.shardKey(record)
+			shardsMu.Lock()
+			s, ok := shards[key]
+			if !ok {
+				s = &shard{}
+				shards[key] = s
+			}
+			shardsMu.Unlock()
+
+			s.mu.Lock()
+			s.records = append(s.records, record)
+			full := len(s.records) >= ig.opts.BatchSize
+			s.mu.Unlock()
+
+			if full {
+				flush(s)
+			}
+
+		case decErr, ok := <-decodeErrs:
+			if !ok {
+				decodeErrs = nil
+				continue
+			}
+			atomic.AddUint64(&ig.decodeErrors, 1)
+			if err := ig.handleDecodeError(decErr); err != nil {
+				consumeErr = err
+				break decodeLoop
+			}
+		}
+	}
+	// If the loop above broke early (ctx canceled or FailFast) rather than
+	// draining both channels to closed, decoder.Decode's goroutine may still
+	// be blocked trying to send on one of them; drain whichever is left open
+	// in the background so it isn't leaked.
+	if records != nil {
+		go func(records <-chan map[string]interface{}) {
+			for range records {
+			}
+		}(records)
+	}
+	if decodeErrs != nil {
+		go func(decodeErrs <-chan error) {
+			for range decodeErrs {
+			}
+		}(decodeErrs)
+	}
+	close(done)
+
+	shardsMu.Lock()
+	for _, s := range shards {
+		flush(s)
+	}
+	shardsMu.Unlock()
+
+	wg.Wait()
+	if consumeErr != nil {
+		return ig.stats(), consumeErr
+	}
+	return ig.stats(), flushErr
+}
+
+// shardKey derives a shard for record from the PrimaryKey field so all
+// records for a given column are buffered in the same per-shard batch. A
+// record whose primary key is missing or unparsable goes to shard 0.
+func (ig *Ingestor) shardKey(record map[string]interface{}) uint64 {
+	for _, f := range ig.def.Fields() {
+		if !f.PrimaryKey {
+			continue
+		}
+		rowID, err := toUint64(record[f.Name])
+		if err != nil {
+			return 0
+		}
+		return rowID
+	}
+	return 0
+}
+
+// applyAndBroadcast runs every field's Action handlers over records and
+// sends the resulting SetBit mutations through the definition's Broadcaster.
+func (ig *Ingestor) applyAndBroadcast(ctx context.Context, records []map[string]interface{}) error {
+recordLoop:
+	for _, record := range records {
+		var columnID uint64
+		for _, f := range ig.def.Fields() {
+			if f.PrimaryKey {
+				id, err := toUint64(record[f.Name])
+				if err != nil {
+					if err := ig.handleApplyError(fmt.Errorf("primary key field %q: %v", f.Name, err), record); err != nil {
+						return err
+					}
+					continue recordLoop
+				}
+				columnID = id
+			}
+		}
+
+		for _, f := range ig.def.Fields() {
+			for _, action := range f.Actions {
+				handler, ok := lookupActionHandler(action.ValueDestination)
+				if !ok {
+					continue
+				}
+
+				start := time.Now()
+				internalAction, err := (&action).Encode()
+				if err != nil {
+					if err := ig.handleApplyError(err, record); err != nil {
+						return err
+					}
+					continue
+				}
+				bits, err := handler.Apply(ctx, f.Name, internalAction, record)
+				ig.actionLatency.Store(f.Name, time.Since(start))
+				if err != nil {
+					if err := ig.handleApplyError(err, record); err != nil {
+						return err
+					}
+					continue
+				}
+
+				for _, b := range bits {
+					b.ColumnID = columnID
+					msg := &internal.SetBitMessage{
+						Index:    ig.def.index,
+						Frame:    action.Frame,
+						RowID:    b.RowID,
+						ColumnID: b.ColumnID,
+					}
+					if err := ig.def.broadcaster.SendSync(msg); err != nil {
+						return err
+					}
+					atomic.AddUint64(&ig.bitsOut, 1)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (ig *Ingestor) handleApplyError(err error, record map[string]interface{}) error {
+	switch ig.opts.ErrorPolicy {
+	case FailFast:
+		return err
+	case DeadLetterPolicy:
+		buf, mErr := json.Marshal(record)
+		if mErr == nil && ig.opts.DeadLetterWriter != nil {
+			_, _ = ig.opts.DeadLetterWriter.Write(append(buf, '\n'))
+		}
+		return nil
+	default: // SkipBadRecord
+		return nil
+	}
+}
+
+func (ig *Ingestor) handleDecodeError(err error) error {
+	switch ig.opts.ErrorPolicy {
+	case FailFast:
+		return fmt.Errorf("failed to decode record: %v", err)
+	case DeadLetterPolicy:
+		if ig.opts.DeadLetterWriter != nil {
+			_, _ = fmt.Fprintf(ig.opts.DeadLetterWriter, "decode error: %v\n", err)
+		}
+		return nil
+	default: // SkipBadRecord
+		return nil
+	}
+}
+
+// stats returns a snapshot of the Ingestor's Prometheus-style counters.
+func (ig *Ingestor) stats() Stats {
+	return Stats{
+		RecordsIn:    atomic.LoadUint64(&ig.recordsIn),
+		BitsOut:      atomic.LoadUint64(&ig.bitsOut),
+		DecodeErrors: atomic.LoadUint64(&ig.decodeErrors),
+	}
+}
+
+// ActionLatency returns the most recently observed Apply duration for the
+// given field's action, for exposition as a Prometheus-style gauge.
+func (ig *Ingestor) ActionLatency(fieldName string) time.Duration {
+	v, ok := ig.actionLatency.Load(fieldName)
+	if !ok {
+		return 0
+	}
+	return v.(time.Duration)
+}
@@ -0,0 +1,137 @@
+// Copyright 2017 Pilosa Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilosa
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/pilosa/pilosa/internal"
+)
+
+// fakeBroadcaster records every SetBitMessage sent through it, standing in
+// for the cluster Broadcaster in tests.
+type fakeBroadcaster struct {
+	sent []*internal.SetBitMessage
+}
+
+func (b *fakeBroadcaster) SendSync(pb proto.Message) error {
+	if m, ok := pb.(*internal.SetBitMessage); ok {
+		b.sent = append(b.sent, m)
+	}
+	return nil
+}
+
+// TestIngestor_Consume_BadPrimaryKeyDoesNotDropRestOfBatch guards against a
+// regression where a record with an unparsable primary key caused
+// applyAndBroadcast to return early, silently discarding every other record
+// flushed in the same batch instead of just skipping the bad one.
+func TestIngestor_Consume_BadPrimaryKeyDoesNotDropRestOfBatch(t *testing.T) {
+	def := &InputDefinition{index: "idx"}
+	def.fields = []Field{
+		{Name: "pk", PrimaryKey: true},
+		{Name: "val", Actions: []Action{{Frame: "f1", ValueDestination: ValueToRow}}},
+	}
+	fb := &fakeBroadcaster{}
+	def.broadcaster = fb
+
+	ig := def.NewIngestor(IngestorOptions{BatchSize: 10, MaxInFlightBatches: 1, FlushInterval: time.Hour})
+
+	// Both records have a primary key that hashes to the same shard (0): the
+	// first because it can't be parsed at all, the second because its valid
+	// primary key value is itself 0. They therefore land in the same
+	// flushed batch.
+	input := `{"pk": "not-a-number", "val": 5}
+{"pk": 0, "val": 7}
+`
+
+	stats, err := ig.Consume(context.Background(), strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.RecordsIn != 2 {
+		t.Fatalf("expected 2 records in, got %d", stats.RecordsIn)
+	}
+	if len(fb.sent) != 1 {
+		t.Fatalf("expected the valid record to still produce a bit despite the bad one preceding it in the batch, got %d messages: %v", len(fb.sent), fb.sent)
+	}
+	if fb.sent[0].RowID != 7 {
+		t.Fatalf("expected rowID 7 for val=7, got %d", fb.sent[0].RowID)
+	}
+}
+
+// TestIngestor_Consume_CSVFormat guards against a regression where CSV
+// records, whose columns decode as plain strings, failed primary-key and
+// action numeric coercion silently under the default SkipBadRecord policy —
+// consuming a valid CSV stream produced zero bits.
+func TestIngestor_Consume_CSVFormat(t *testing.T) {
+	def := &InputDefinition{index: "idx"}
+	def.fields = []Field{
+		{Name: "pk", PrimaryKey: true},
+		{Name: "val", Actions: []Action{{Frame: "f1", ValueDestination: ValueToRow}}},
+	}
+	def.format = FormatCSV
+	decoder, err := newRecordDecoder(def.format, nil)
+	if err != nil {
+		t.Fatalf("unexpected error building decoder: %v", err)
+	}
+	def.decoder = decoder
+
+	fb := &fakeBroadcaster{}
+	def.broadcaster = fb
+
+	ig := def.NewIngestor(IngestorOptions{BatchSize: 10, MaxInFlightBatches: 1, FlushInterval: time.Hour})
+
+	input := "pk,val\n1,5\n2,7\n"
+
+	stats, err := ig.Consume(context.Background(), strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.RecordsIn != 2 {
+		t.Fatalf("expected 2 records in, got %d", stats.RecordsIn)
+	}
+	if stats.DecodeErrors != 0 {
+		t.Fatalf("expected no decode errors, got %d", stats.DecodeErrors)
+	}
+	if stats.BitsOut != 2 {
+		t.Fatalf("expected 2 bits out, got %d", stats.BitsOut)
+	}
+	if len(fb.sent) != 2 {
+		t.Fatalf("expected 2 SetBitMessages, got %d: %v", len(fb.sent), fb.sent)
+	}
+}
+
+func TestIngestor_Consume_FailFastStopsOnBadRecord(t *testing.T) {
+	def := &InputDefinition{index: "idx"}
+	def.fields = []Field{{Name: "pk", PrimaryKey: true}}
+	def.broadcaster = &fakeBroadcaster{}
+
+	ig := def.NewIngestor(IngestorOptions{
+		BatchSize:          10,
+		MaxInFlightBatches: 1,
+		FlushInterval:      time.Hour,
+		ErrorPolicy:        FailFast,
+	})
+
+	input := `{"pk": "not-a-number"}
+`
+	if _, err := ig.Consume(context.Background(), strings.NewReader(input)); err == nil {
+		t.Fatal("expected FailFast to surface the primary key error")
+	}
+}
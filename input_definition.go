@@ -29,10 +29,10 @@ const (
 	Mapping       = "mapping"
 	ValueToRow    = "value-to-row"
 	SingleRowBool = "single-row-boolean"
+	IntBSI        = "int-bsi"
+	StringHash    = "string-hash"
 )
 
-var ValidValueDestination = []string{Mapping, ValueToRow, SingleRowBool}
-
 // InputDefinition represents a container for the data input definition.
 type InputDefinition struct {
 	name        string
@@ -41,6 +41,19 @@ type InputDefinition struct {
 	broadcaster Broadcaster
 	frames      []InputFrame
 	fields      []Field
+	version     uint32
+
+	// format and formatOptions select the RecordDecoder used by Open to
+	// interpret the raw bytes handed to an input HTTP endpoint. format
+	// defaults to FormatJSON when unset.
+	format        string
+	formatOptions map[string]string
+	decoder       RecordDecoder
+
+	// ValidateOnly puts UpdateDefinition into dry-run mode: validators still
+	// run and any violations are still returned, but the result is never
+	// persisted to disk.
+	ValidateOnly bool
 }
 
 // NewInputDefinition returns a new instance of InputDefinition.
@@ -63,6 +76,10 @@ func (i *InputDefinition) Frames() []InputFrame { return i.frames }
 // Fields returns fields of the input definition was initialized with.
 func (i *InputDefinition) Fields() []Field { return i.fields }
 
+// Version returns the schema version of the input definition. Version is
+// incremented every time UpdateDefinition persists a change.
+func (i *InputDefinition) Version() uint32 { return i.version }
+
 // Open opens and initializes the InputDefinition from file.
 func (i *InputDefinition) Open() error {
 	if err := func() error {
@@ -77,13 +94,35 @@ func (i *InputDefinition) Open() error {
 	}(); err != nil {
 		return err
 	}
+
+	decoder, err := newRecordDecoder(i.format, i.formatOptions)
+	if err != nil {
+		return err
+	}
+	i.decoder = decoder
+
 	return nil
 }
 
+// Format returns the record format (e.g. FormatJSON, FormatCSV, FormatAvro)
+// configured for this definition's input endpoint.
+func (i *InputDefinition) Format() string { return i.format }
+
+// FormatOptions returns the format-specific options (e.g. CSV delimiter, the
+// Avro schema-registry URL) configured for this definition's input endpoint.
+func (i *InputDefinition) FormatOptions() map[string]string { return i.formatOptions }
+
+// Decoder returns the RecordDecoder selected by Format/FormatOptions. It is
+// populated by Open.
+func (i *InputDefinition) Decoder() RecordDecoder { return i.decoder }
+
 // LoadDefinition loads the protobuf format of a defition
 func (i *InputDefinition) LoadDefinition(pb *internal.InputDefinition) error {
 	// Copy metadata fields.
 	i.name = pb.Name
+	i.version = pb.Version
+	i.format = pb.Format
+	i.formatOptions = pb.FormatOptions
 	for _, fr := range pb.Frames {
 		frameMeta := fr.Meta
 		inputFrame := InputFrame{
@@ -120,6 +159,9 @@ func (i *InputDefinition) LoadDefinition(pb *internal.InputDefinition) error {
 				ValueDestination: action.ValueDestination,
 				ValueMap:         action.ValueMap,
 				RowID:            &action.RowID,
+				Min:              &action.Min,
+				Max:              &action.Max,
+				Modulus:          &action.Modulus,
 			})
 		}
 		if field.PrimaryKey {
@@ -154,12 +196,11 @@ func (i *InputDefinition) loadMeta() error {
 	return i.LoadDefinition(&pb)
 }
 
-//saveMeta writes meta data for the input definition file.
-func (i *InputDefinition) saveMeta() error {
-	if err := os.MkdirAll(i.path, 0777); err != nil {
-		return err
-	}
-	// Marshal metadata.
+// buildProto marshals the InputDefinition's current frames/fields into its
+// protobuf representation. It's the single meta-builder shared by saveMeta
+// and saveMetaVersioned, so the two writers can't drift from each other (and
+// from Field/Action's own Encode) as format fields are added.
+func (i *InputDefinition) buildProto() (*internal.InputDefinition, error) {
 	var frames []*internal.Frame
 	for _, fr := range i.frames {
 		frameMeta := &internal.FrameMeta{
@@ -169,45 +210,76 @@ func (i *InputDefinition) saveMeta() error {
 			CacheSize:      fr.Options.CacheSize,
 			TimeQuantum:    string(fr.Options.TimeQuantum),
 		}
-		frame := &internal.Frame{Name: fr.Name, Meta: frameMeta}
-		frames = append(frames, frame)
+		frames = append(frames, &internal.Frame{Name: fr.Name, Meta: frameMeta})
 	}
 
 	var fields []*internal.InputDefinitionField
 	for _, field := range i.fields {
-		var actions []*internal.Action
-		for _, action := range field.Actions {
-			actionMeta := &internal.Action{
-				Frame:            action.Frame,
-				ValueDestination: action.ValueDestination,
-				ValueMap:         action.ValueMap,
-				RowID:            convert(action.RowID),
-			}
-			actions = append(actions, actionMeta)
+		fieldEncode, err := field.Encode()
+		if err != nil {
+			return nil, err
 		}
+		fields = append(fields, fieldEncode)
+	}
 
-		fieldMeta := &internal.InputDefinitionField{
-			Name:       field.Name,
-			PrimaryKey: field.PrimaryKey,
-			Actions:    actions,
-		}
-		fields = append(fields, fieldMeta)
+	return &internal.InputDefinition{
+		Name:          i.name,
+		Version:       i.version,
+		Format:        i.format,
+		FormatOptions: i.formatOptions,
+		Frames:        frames,
+		Fields:        fields,
+	}, nil
+}
+
+// saveMeta writes the current metadata for the input definition file.
+func (i *InputDefinition) saveMeta() error {
+	if err := os.MkdirAll(i.path, 0777); err != nil {
+		return err
+	}
+
+	pb, err := i.buildProto()
+	if err != nil {
+		return err
 	}
-	buf, err := proto.Marshal(&internal.InputDefinition{
-		Name:   i.name,
-		Frames: frames,
-		Fields: fields,
-	})
+	buf, err := proto.Marshal(pb)
 	if err != nil {
 		return err
 	}
 
-	// Write to meta file.
-	if err := ioutil.WriteFile(filepath.Join(i.path, i.name), buf, 0666); err != nil {
+	return ioutil.WriteFile(filepath.Join(i.path, i.name), buf, 0666)
+}
+
+// saveMetaVersioned atomically writes the current metadata to a versioned
+// file (`<name>.vN`) so that a prior version can still be recovered after a
+// later UpdateDefinition call. The write-then-rename sequence guarantees
+// readers of `<name>.vN` never observe a partially written file; the
+// canonical `<name>` file is then overwritten in place, without a rename.
+func (i *InputDefinition) saveMetaVersioned() error {
+	if err := os.MkdirAll(i.path, 0777); err != nil {
+		return err
+	}
+
+	pb, err := i.buildProto()
+	if err != nil {
+		return err
+	}
+	buf, err := proto.Marshal(pb)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	versionedPath := filepath.Join(i.path, fmt.Sprintf("%s.v%d", i.name, i.version))
+	tmpPath := versionedPath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, buf, 0666); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, versionedPath); err != nil {
+		return err
+	}
+
+	// Point the canonical meta file at the same bytes as the versioned one.
+	return ioutil.WriteFile(filepath.Join(i.path, i.name), buf, 0666)
 }
 
 // Field descripes a single field mapping in the InputDefinition.
@@ -237,6 +309,9 @@ type Action struct {
 	ValueDestination string            `json:"valueDestination,omitempty"`
 	ValueMap         map[string]uint64 `json:"valueMap,omitempty"`
 	RowID            *uint64           `json:"rowID,omitempty"`
+	Min              *int64            `json:"min,omitempty"`
+	Max              *int64            `json:"max,omitempty"`
+	Modulus          *uint64           `json:"modulus,omitempty"`
 }
 
 // Encode converts Action into its internal representation.
@@ -249,6 +324,9 @@ func (o *Action) Encode() (*internal.Action, error) {
 		ValueDestination: o.ValueDestination,
 		ValueMap:         o.ValueMap,
 		RowID:            convert(o.RowID),
+		Min:              convertInt(o.Min),
+		Max:              convertInt(o.Max),
+		Modulus:          convert(o.Modulus),
 	}, nil
 }
 
@@ -259,6 +337,13 @@ func convert(x *uint64) uint64 {
 	return 0
 }
 
+func convertInt(x *int64) int64 {
+	if x != nil {
+		return *x
+	}
+	return 0
+}
+
 // InputFrame defines the frame used in the input definition.
 type InputFrame struct {
 	Name    string       `json:"name,omitempty"`
@@ -300,18 +385,9 @@ func (i *InputDefinition) ValidateAction(action *internal.Action) error {
 	if action.Frame == "" {
 		return ErrFrameRequired
 	}
-	validValues := make(map[string]bool)
-	for _, val := range ValidValueDestination {
-		validValues[val] = true
-	}
-	if _, ok := validValues[action.ValueDestination]; !ok {
+	handler, ok := lookupActionHandler(action.ValueDestination)
+	if !ok {
 		return fmt.Errorf("invalid ValueDestination: %s", action.ValueDestination)
 	}
-	switch action.ValueDestination {
-	case Mapping:
-		if len(action.ValueMap) == 0 {
-			return errors.New("valueMap required for map")
-		}
-	}
-	return nil
+	return handler.Validate(action)
 }
\ No newline at end of file
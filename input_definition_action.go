@@ -0,0 +1,254 @@
+// Copyright 2017 Pilosa Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilosa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	"strconv"
+
+	"github.com/pilosa/pilosa/internal"
+)
+
+// ActionHandler implements one ValueDestination kind: it validates an
+// action's configuration and turns a record's raw field value into the Bits
+// that should be set. Third-party code can add new ValueDestination kinds by
+// implementing ActionHandler and calling RegisterActionHandler at init time,
+// without modifying this package.
+type ActionHandler interface {
+	// Name returns the ValueDestination string this handler serves.
+	Name() string
+
+	// Validate checks that action is configured correctly for this handler,
+	// e.g. that required fields like ValueMap or RowID are present.
+	Validate(action *internal.Action) error
+
+	// Apply converts the raw value of fieldName within row into the Bits it
+	// maps to. The returned Bits have RowID (and, for BSI-style handlers,
+	// multiple rows) populated; the caller is responsible for setting
+	// ColumnID from the record's primary key before writing them.
+	Apply(ctx context.Context, fieldName string, action *internal.Action, row map[string]interface{}) ([]Bit, error)
+}
+
+var actionHandlers = make(map[string]ActionHandler)
+
+// RegisterActionHandler registers h under h.Name(), making it available as a
+// ValueDestination for InputDefinition fields. Registering a handler under a
+// name that is already registered replaces the previous handler, so built-in
+// handlers may be overridden by calling this from an init function that runs
+// later.
+func RegisterActionHandler(h ActionHandler) {
+	actionHandlers[h.Name()] = h
+}
+
+func lookupActionHandler(name string) (ActionHandler, bool) {
+	h, ok := actionHandlers[name]
+	return h, ok
+}
+
+func init() {
+	RegisterActionHandler(mappingHandler{})
+	RegisterActionHandler(valueToRowHandler{})
+	RegisterActionHandler(singleRowBoolHandler{})
+	RegisterActionHandler(intBSIHandler{})
+	RegisterActionHandler(stringHashHandler{})
+}
+
+// mappingHandler implements Mapping: the raw string value is looked up in
+// action.ValueMap to find the row it sets.
+type mappingHandler struct{}
+
+func (mappingHandler) Name() string { return Mapping }
+
+func (mappingHandler) Validate(action *internal.Action) error {
+	if len(action.ValueMap) == 0 {
+		return errors.New("valueMap required for map")
+	}
+	return nil
+}
+
+func (mappingHandler) Apply(ctx context.Context, fieldName string, action *internal.Action, row map[string]interface{}) ([]Bit, error) {
+	val := fmt.Sprintf("%v", row[fieldName])
+	rowID, ok := action.ValueMap[val]
+	if !ok {
+		return nil, fmt.Errorf("value %q for field %q has no entry in valueMap", val, fieldName)
+	}
+	return []Bit{{RowID: rowID}}, nil
+}
+
+// valueToRowHandler implements ValueToRow: the raw value is itself the row
+// ID to set, so no further translation is needed.
+type valueToRowHandler struct{}
+
+func (valueToRowHandler) Name() string { return ValueToRow }
+
+func (valueToRowHandler) Validate(action *internal.Action) error { return nil }
+
+func (valueToRowHandler) Apply(ctx context.Context, fieldName string, action *internal.Action, row map[string]interface{}) ([]Bit, error) {
+	rowID, err := toUint64(row[fieldName])
+	if err != nil {
+		return nil, fmt.Errorf("field %q: %v", fieldName, err)
+	}
+	return []Bit{{RowID: rowID}}, nil
+}
+
+// singleRowBoolHandler implements SingleRowBool: a fixed RowID is set
+// whenever the raw value is truthy.
+type singleRowBoolHandler struct{}
+
+func (singleRowBoolHandler) Name() string { return SingleRowBool }
+
+func (singleRowBoolHandler) Validate(action *internal.Action) error {
+	if action.RowID == 0 {
+		return errors.New("rowID required for single-row-boolean")
+	}
+	return nil
+}
+
+func (singleRowBoolHandler) Apply(ctx context.Context, fieldName string, action *internal.Action, row map[string]interface{}) ([]Bit, error) {
+	truthy, err := toBool(row[fieldName])
+	if err != nil {
+		return nil, fmt.Errorf("field %q: %v", fieldName, err)
+	}
+	if !truthy {
+		return nil, nil
+	}
+	return []Bit{{RowID: action.RowID}}, nil
+}
+
+// intBSIHandler implements IntBSI: a numeric field is range-encoded
+// bit-sliced across [Min, Max]. Row 0..n-1 are the bit planes of
+// (value - Min); row n is an "exists" row set for every non-null value, so
+// a BSI range scan can distinguish "value is 0" from "value is absent".
+type intBSIHandler struct{}
+
+func (intBSIHandler) Name() string { return IntBSI }
+
+func (intBSIHandler) Validate(action *internal.Action) error {
+	if action.Min >= action.Max {
+		return errors.New("min must be less than max for int-bsi")
+	}
+	return nil
+}
+
+func (intBSIHandler) Apply(ctx context.Context, fieldName string, action *internal.Action, row map[string]interface{}) ([]Bit, error) {
+	raw, ok := row[fieldName]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	val, err := toInt64(raw)
+	if err != nil {
+		return nil, fmt.Errorf("field %q: %v", fieldName, err)
+	}
+	if val < action.Min || val > action.Max {
+		return nil, fmt.Errorf("field %q: value %d out of range [%d,%d]", fieldName, val, action.Min, action.Max)
+	}
+
+	span := uint64(action.Max - action.Min)
+	numBits := bits.Len64(span)
+	offset := uint64(val - action.Min)
+
+	out := make([]Bit, 0, numBits+1)
+	for plane := 0; plane < numBits; plane++ {
+		if offset&(1<<uint(plane)) != 0 {
+			out = append(out, Bit{RowID: uint64(plane)})
+		}
+	}
+	out = append(out, Bit{RowID: uint64(numBits)}) // exists row
+	return out, nil
+}
+
+// stringHashHandler implements StringHash: a string value is hashed into a
+// bounded [0, Modulus) row-id space, for high-cardinality categoricals where
+// a full ValueMap is impractical to maintain.
+type stringHashHandler struct{}
+
+func (stringHashHandler) Name() string { return StringHash }
+
+func (stringHashHandler) Validate(action *internal.Action) error {
+	if action.Modulus == 0 {
+		return errors.New("modulus required for string-hash")
+	}
+	return nil
+}
+
+func (stringHashHandler) Apply(ctx context.Context, fieldName string, action *internal.Action, row map[string]interface{}) ([]Bit, error) {
+	val := fmt.Sprintf("%v", row[fieldName])
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(val))
+	return []Bit{{RowID: h.Sum64() % action.Modulus}}, nil
+}
+
+func toUint64(v interface{}) (uint64, error) {
+	switch n := v.(type) {
+	case uint64:
+		return n, nil
+	case int64:
+		return uint64(n), nil
+	case int:
+		return uint64(n), nil
+	case float64:
+		return uint64(n), nil
+	case string:
+		// CSV records carry every column as a string; accept a decimal
+		// numeral the same way the other numeric kinds are accepted.
+		u, err := strconv.ParseUint(n, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("cannot convert %q to rowID", n)
+		}
+		return u, nil
+	default:
+		return 0, fmt.Errorf("cannot convert %T to rowID", v)
+	}
+}
+
+func toInt64(v interface{}) (int64, error) {
+	switch n := v.(type) {
+	case int64:
+		return n, nil
+	case int:
+		return int64(n), nil
+	case uint64:
+		return int64(n), nil
+	case float64:
+		return int64(n), nil
+	case string:
+		// CSV records carry every column as a string; accept a decimal
+		// numeral the same way the other numeric kinds are accepted.
+		i, err := strconv.ParseInt(n, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("cannot convert %q to int64", n)
+		}
+		return i, nil
+	default:
+		return 0, fmt.Errorf("cannot convert %T to int64", v)
+	}
+}
+
+func toBool(v interface{}) (bool, error) {
+	switch b := v.(type) {
+	case bool:
+		return b, nil
+	case string:
+		return b != "" && b != "false" && b != "0", nil
+	case nil:
+		return false, nil
+	default:
+		return false, fmt.Errorf("cannot convert %T to bool", v)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2017 Pilosa Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilosa
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pilosa/pilosa/internal"
+)
+
+func TestIntBSIHandler_Apply(t *testing.T) {
+	handler, ok := lookupActionHandler(IntBSI)
+	if !ok {
+		t.Fatal("int-bsi handler not registered")
+	}
+
+	action := &internal.Action{Frame: "f", ValueDestination: IntBSI, Min: 0, Max: 15}
+	if err := handler.Validate(action); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+
+	bits, err := handler.Apply(context.Background(), "val", action, map[string]interface{}{"val": int64(5)})
+	if err != nil {
+		t.Fatalf("unexpected apply error: %v", err)
+	}
+
+	// 5 = 0b0101 -> bit planes 0 and 2 set, plus the trailing "exists" row.
+	got := make(map[uint64]bool)
+	for _, b := range bits {
+		got[b.RowID] = true
+	}
+	for _, want := range []uint64{0, 2, 4} {
+		if !got[want] {
+			t.Errorf("expected row %d to be set for value 5, got %v", want, bits)
+		}
+	}
+	if got[1] || got[3] {
+		t.Errorf("did not expect bit planes 1 or 3 to be set for value 5, got %v", bits)
+	}
+}
+
+func TestIntBSIHandler_Validate_RejectsInvertedRange(t *testing.T) {
+	handler, _ := lookupActionHandler(IntBSI)
+	action := &internal.Action{Frame: "f", ValueDestination: IntBSI, Min: 10, Max: 5}
+	if err := handler.Validate(action); err == nil {
+		t.Fatal("expected validation error for min >= max")
+	}
+}
+
+func TestStringHashHandler_Apply_IsBoundedAndDeterministic(t *testing.T) {
+	handler, ok := lookupActionHandler(StringHash)
+	if !ok {
+		t.Fatal("string-hash handler not registered")
+	}
+
+	action := &internal.Action{Frame: "f", ValueDestination: StringHash, Modulus: 16}
+	row := map[string]interface{}{"category": "electronics"}
+
+	first, err := handler.Apply(context.Background(), "category", action, row)
+	if err != nil {
+		t.Fatalf("unexpected apply error: %v", err)
+	}
+	second, err := handler.Apply(context.Background(), "category", action, row)
+	if err != nil {
+		t.Fatalf("unexpected apply error: %v", err)
+	}
+
+	if len(first) != 1 || len(second) != 1 {
+		t.Fatalf("expected exactly one bit, got %v and %v", first, second)
+	}
+	if first[0].RowID != second[0].RowID {
+		t.Fatalf("expected hashing the same value twice to be deterministic, got %d and %d", first[0].RowID, second[0].RowID)
+	}
+	if first[0].RowID >= action.Modulus {
+		t.Fatalf("expected rowID < modulus (%d), got %d", action.Modulus, first[0].RowID)
+	}
+}
+
+func TestRegisterActionHandler_Overrides(t *testing.T) {
+	const name = "test-custom-destination"
+	calls := 0
+	RegisterActionHandler(fakeActionHandler{name: name, onApply: func() { t.Fatal("expected the earlier handler to have been replaced") }})
+	RegisterActionHandler(fakeActionHandler{name: name, onApply: func() { calls++ }})
+
+	handler, ok := lookupActionHandler(name)
+	if !ok {
+		t.Fatal("expected custom handler to be registered")
+	}
+	if _, err := handler.Apply(context.Background(), "f", &internal.Action{}, nil); err != nil {
+		t.Fatalf("unexpected apply error: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected custom handler to be invoked once, got %d", calls)
+	}
+}
+
+type fakeActionHandler struct {
+	name    string
+	onApply func()
+}
+
+func (h fakeActionHandler) Name() string                        { return h.name }
+func (h fakeActionHandler) Validate(action *internal.Action) error { return nil }
+func (h fakeActionHandler) Apply(ctx context.Context, fieldName string, action *internal.Action, row map[string]interface{}) ([]Bit, error) {
+	h.onApply()
+	return nil, nil
+}
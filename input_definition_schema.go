@@ -0,0 +1,259 @@
+// Copyright 2017 Pilosa Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilosa
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// DefinitionValidator checks a proposed change to an InputDefinition for
+// upgrade-safety. Validators are run against the definition currently on
+// disk (old) and the definition being proposed (new); they must not mutate
+// either argument.
+type DefinitionValidator interface {
+	Validate(old, new *InputDefinition) error
+}
+
+// definitionValidators holds the set of upgrade-safety checks run by
+// UpdateDefinition, in order.
+var definitionValidators = []DefinitionValidator{
+	fieldRemovalValidator{},
+	destinationNarrowingValidator{},
+	frameRemovalValidator{},
+	rowIDCollisionValidator{},
+}
+
+// SchemaViolations aggregates every upgrade-safety violation found while
+// validating an UpdateDefinition call, so a caller sees all of them at once
+// instead of failing on the first.
+type SchemaViolations []error
+
+func (v SchemaViolations) Error() string {
+	msgs := make([]string, len(v))
+	for i, err := range v {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// destinationWidth orders ValueDestination kinds from widest (can represent
+// the most information) to narrowest, so a change can be detected as a
+// narrowing even when the two kinds aren't byte-for-byte equal. Every
+// ValueDestination registered in input_definition_action.go needs an entry
+// here, or it silently inherits width 0 and is treated as the narrowest
+// possible destination.
+var destinationWidth = map[string]int{
+	IntBSI:        4, // range-encodes the full value, losslessly
+	Mapping:       3,
+	ValueToRow:    2,
+	StringHash:    1, // lossy: distinct values can hash to the same row
+	SingleRowBool: 0,
+}
+
+// fieldRemovalValidator rejects removing or renaming a field that already
+// has a stored ValueMap entry, since the mapped row IDs would become
+// unreachable.
+type fieldRemovalValidator struct{}
+
+func (fieldRemovalValidator) Validate(old, new *InputDefinition) error {
+	newFields := make(map[string]bool)
+	for _, f := range new.fields {
+		newFields[f.Name] = true
+	}
+
+	var violations SchemaViolations
+	for _, f := range old.fields {
+		if newFields[f.Name] {
+			continue
+		}
+		for _, action := range f.Actions {
+			if len(action.ValueMap) > 0 {
+				violations = append(violations, fmt.Errorf("field %q has a stored valueMap and cannot be removed or renamed", f.Name))
+				break
+			}
+		}
+	}
+	if len(violations) > 0 {
+		return violations
+	}
+	return nil
+}
+
+// destinationNarrowingValidator rejects narrowing a field's
+// ValueDestination, e.g. Mapping -> SingleRowBool, since existing stored
+// values may no longer be representable.
+type destinationNarrowingValidator struct{}
+
+func (destinationNarrowingValidator) Validate(old, new *InputDefinition) error {
+	oldActions := make(map[string]Action)
+	for _, f := range old.fields {
+		for _, action := range f.Actions {
+			oldActions[f.Name+"/"+action.Frame] = action
+		}
+	}
+
+	var violations SchemaViolations
+	for _, f := range new.fields {
+		for _, action := range f.Actions {
+			prev, ok := oldActions[f.Name+"/"+action.Frame]
+			if !ok {
+				continue
+			}
+			if destinationWidth[action.ValueDestination] < destinationWidth[prev.ValueDestination] {
+				violations = append(violations, fmt.Errorf("field %q: narrowing valueDestination from %q to %q is not allowed", f.Name, prev.ValueDestination, action.ValueDestination))
+			}
+		}
+	}
+	if len(violations) > 0 {
+		return violations
+	}
+	return nil
+}
+
+// frameRemovalValidator rejects removing a frame that is still referenced by
+// an action in the new field set.
+type frameRemovalValidator struct{}
+
+func (frameRemovalValidator) Validate(old, new *InputDefinition) error {
+	newFrames := make(map[string]bool)
+	for _, fr := range new.frames {
+		newFrames[fr.Name] = true
+	}
+
+	referenced := make(map[string]bool)
+	for _, f := range new.fields {
+		for _, action := range f.Actions {
+			referenced[action.Frame] = true
+		}
+	}
+
+	var violations SchemaViolations
+	for _, fr := range old.frames {
+		if newFrames[fr.Name] {
+			continue
+		}
+		if referenced[fr.Name] {
+			violations = append(violations, fmt.Errorf("frame %q is still referenced by an action and cannot be removed", fr.Name))
+		}
+	}
+	if len(violations) > 0 {
+		return violations
+	}
+	return nil
+}
+
+// rowIDCollisionValidator rejects a merged field set in which two actions
+// targeting the same frame would write overlapping RowIDs. single-row-boolean
+// claims one fixed row; int-bsi range-encodes into the full 0..numBits span
+// of the frame (see intBSIHandler.Apply), so two int-bsi fields sharing a
+// frame, or an int-bsi field sharing a frame with a single-row-boolean
+// action, collide just as surely as two single-row-boolean fields do.
+type rowIDCollisionValidator struct{}
+
+func (rowIDCollisionValidator) Validate(old, new *InputDefinition) error {
+	seen := make(map[string]string)   // frame/rowID -> field name
+	reported := make(map[string]bool) // frame/fieldA/fieldB already reported
+
+	claim := func(violations *SchemaViolations, frame, fieldName string, rowID uint64) {
+		key := fmt.Sprintf("%s/%d", frame, rowID)
+		owner, ok := seen[key]
+		if !ok {
+			seen[key] = fieldName
+			return
+		}
+		if owner == fieldName {
+			return
+		}
+		pairKey := fmt.Sprintf("%s/%s/%s", frame, owner, fieldName)
+		if reported[pairKey] {
+			return
+		}
+		reported[pairKey] = true
+		*violations = append(*violations, fmt.Errorf("rowID %d in frame %q is claimed by both field %q and field %q", rowID, frame, owner, fieldName))
+	}
+
+	var violations SchemaViolations
+	for _, f := range new.fields {
+		for _, action := range f.Actions {
+			switch action.ValueDestination {
+			case SingleRowBool:
+				if action.RowID == nil {
+					continue
+				}
+				claim(&violations, action.Frame, f.Name, *action.RowID)
+			case IntBSI:
+				if action.Min == nil || action.Max == nil || *action.Min >= *action.Max {
+					continue
+				}
+				numBits := bits.Len64(uint64(*action.Max - *action.Min))
+				for row := 0; row <= numBits; row++ {
+					claim(&violations, action.Frame, f.Name, uint64(row))
+				}
+			}
+		}
+	}
+	if len(violations) > 0 {
+		return violations
+	}
+	return nil
+}
+
+// UpdateDefinition validates a proposed InputDefinitionInfo against the
+// definition's current state using the registered DefinitionValidators, and
+// on success atomically persists it as a new schema version.
+//
+// If ValidateOnly is set, all violations are still reported but nothing is
+// written to disk and the in-memory definition is left unchanged.
+func (i *InputDefinition) UpdateDefinition(new *InputDefinitionInfo) error {
+	pb, err := new.Encode()
+	if err != nil {
+		return err
+	}
+
+	candidate := &InputDefinition{name: i.name, path: i.path, index: i.index}
+	if err := candidate.LoadDefinition(pb); err != nil {
+		return err
+	}
+	// LoadDefinition copies pb.Version verbatim (0, since new was built from
+	// an InputDefinitionInfo rather than loaded off disk) — the bump to the
+	// next schema version has to happen after it returns.
+	candidate.version = i.version + 1
+
+	var violations SchemaViolations
+	for _, validator := range definitionValidators {
+		if err := validator.Validate(i, candidate); err != nil {
+			if sv, ok := err.(SchemaViolations); ok {
+				violations = append(violations, sv...)
+			} else {
+				violations = append(violations, err)
+			}
+		}
+	}
+	if len(violations) > 0 {
+		return violations
+	}
+
+	if i.ValidateOnly {
+		return nil
+	}
+
+	i.frames = candidate.frames
+	i.fields = candidate.fields
+	i.version = candidate.version
+
+	return i.saveMetaVersioned()
+}
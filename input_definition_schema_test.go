@@ -0,0 +1,183 @@
+// Copyright 2017 Pilosa Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilosa
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInputDefinition_UpdateDefinition_VersionIncrements(t *testing.T) {
+	dir, err := ioutilTempDir(t)
+	if err != nil {
+		t.Fatalf("creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	def := &InputDefinition{path: dir, index: "idx", name: "def"}
+	def.frames = []InputFrame{{Name: "f1"}}
+	def.fields = []Field{{Name: "id", PrimaryKey: true}}
+
+	info := &InputDefinitionInfo{
+		Frames: []InputFrame{{Name: "f1"}},
+		Fields: []Field{{Name: "id", PrimaryKey: true}},
+	}
+
+	if err := def.UpdateDefinition(info); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if def.Version() != 1 {
+		t.Fatalf("expected version 1 after first update, got %d", def.Version())
+	}
+
+	if err := def.UpdateDefinition(info); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if def.Version() != 2 {
+		t.Fatalf("expected version 2 after second update, got %d", def.Version())
+	}
+
+	for _, v := range []uint32{1, 2} {
+		versionedPath := filepath.Join(dir, fmt.Sprintf("def.v%d", v))
+		if _, err := os.Stat(versionedPath); err != nil {
+			t.Errorf("expected versioned meta file %s to exist: %v", versionedPath, err)
+		}
+	}
+}
+
+func TestInputDefinition_UpdateDefinition_ValidateOnlyDoesNotPersist(t *testing.T) {
+	dir, err := ioutilTempDir(t)
+	if err != nil {
+		t.Fatalf("creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	def := &InputDefinition{path: dir, index: "idx", name: "def", ValidateOnly: true}
+	def.fields = []Field{{Name: "id", PrimaryKey: true}}
+
+	info := &InputDefinitionInfo{
+		Fields: []Field{{Name: "id", PrimaryKey: true}},
+	}
+
+	if err := def.UpdateDefinition(info); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if def.Version() != 0 {
+		t.Fatalf("expected ValidateOnly to leave version untouched, got %d", def.Version())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "def.v1")); !os.IsNotExist(err) {
+		t.Fatalf("expected ValidateOnly to not write a versioned meta file")
+	}
+}
+
+func TestInputDefinition_UpdateDefinition_RejectsFieldRemovalWithValueMap(t *testing.T) {
+	def := &InputDefinition{name: "def"}
+	def.fields = []Field{
+		{
+			Name: "color",
+			Actions: []Action{
+				{Frame: "f1", ValueDestination: Mapping, ValueMap: map[string]uint64{"red": 0}},
+			},
+		},
+	}
+
+	info := &InputDefinitionInfo{} // removes the "color" field entirely
+
+	if err := def.UpdateDefinition(info); err == nil {
+		t.Fatal("expected removal of a field with a stored valueMap to be rejected")
+	}
+}
+
+func TestInputDefinition_UpdateDefinition_IntBSIWidth(t *testing.T) {
+	dir, err := ioutilTempDir(t)
+	if err != nil {
+		t.Fatalf("creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	min, max := int64(0), int64(100)
+
+	def := &InputDefinition{path: dir, index: "idx", name: "def"}
+	def.fields = []Field{
+		{Name: "temp", Actions: []Action{
+			{Frame: "f1", ValueDestination: Mapping, ValueMap: map[string]uint64{"hot": 0}},
+		}},
+	}
+
+	info := &InputDefinitionInfo{
+		Fields: []Field{
+			{Name: "temp", Actions: []Action{
+				{Frame: "f1", ValueDestination: IntBSI, Min: &min, Max: &max},
+			}},
+		},
+	}
+
+	if err := def.UpdateDefinition(info); err != nil {
+		t.Fatalf("mapping -> int-bsi should not be treated as a narrowing: %v", err)
+	}
+}
+
+func TestInputDefinition_UpdateDefinition_RejectsIntBSIToSingleRowBoolNarrowing(t *testing.T) {
+	min, max := int64(0), int64(100)
+	rowID := uint64(3)
+
+	def := &InputDefinition{name: "def"}
+	def.fields = []Field{
+		{Name: "temp", Actions: []Action{
+			{Frame: "f1", ValueDestination: IntBSI, Min: &min, Max: &max},
+		}},
+	}
+
+	info := &InputDefinitionInfo{
+		Fields: []Field{
+			{Name: "temp", Actions: []Action{
+				{Frame: "f1", ValueDestination: SingleRowBool, RowID: &rowID},
+			}},
+		},
+	}
+
+	if err := def.UpdateDefinition(info); err == nil {
+		t.Fatal("expected narrowing int-bsi to single-row-boolean to be rejected")
+	}
+}
+
+func TestInputDefinition_UpdateDefinition_RejectsIntBSIRowIDCollision(t *testing.T) {
+	min, max := int64(0), int64(100)
+	rowID := uint64(6) // within [0, numBits] for an int-bsi over [0,100]
+
+	def := &InputDefinition{name: "def"}
+
+	info := &InputDefinitionInfo{
+		Fields: []Field{
+			{Name: "temp", Actions: []Action{
+				{Frame: "f1", ValueDestination: IntBSI, Min: &min, Max: &max},
+			}},
+			{Name: "flag", Actions: []Action{
+				{Frame: "f1", ValueDestination: SingleRowBool, RowID: &rowID},
+			}},
+		},
+	}
+
+	if err := def.UpdateDefinition(info); err == nil {
+		t.Fatal("expected int-bsi and single-row-boolean sharing a frame's rows to be rejected")
+	}
+}
+
+func ioutilTempDir(t *testing.T) (string, error) {
+	return ioutil.TempDir("", "pilosa-input-definition-")
+}
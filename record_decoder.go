@@ -0,0 +1,369 @@
+// Copyright 2017 Pilosa Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilosa
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// Supported InputDefinition.Format values.
+const (
+	FormatJSON = "json-lines"
+	FormatCSV  = "csv"
+	FormatAvro = "avro"
+)
+
+// RecordDecoder turns the raw bytes accepted by an input HTTP endpoint into
+// a stream of records keyed by Field.Name, so InputDefinition's Actions can
+// be applied the same way regardless of wire format.
+type RecordDecoder interface {
+	// Decode reads r until EOF, sending one decoded record per line/row on
+	// the returned channel and any decode error on the error channel. Both
+	// channels are closed when r is exhausted. Records whose keys don't
+	// match any of fields are reported as errors rather than silently
+	// passed through.
+	Decode(r io.Reader, fields []Field) (<-chan map[string]interface{}, <-chan error)
+}
+
+// recordDecoderFactories maps a Format string to a constructor for its
+// RecordDecoder, so ContentType-driven dispatch (e.g. from the
+// /index/<i>/input/<name> handler) stays in one place.
+var recordDecoderFactories = map[string]func(options map[string]string) (RecordDecoder, error){
+	FormatJSON: func(options map[string]string) (RecordDecoder, error) { return jsonLinesDecoder{}, nil },
+	FormatCSV:  newCSVDecoder,
+	FormatAvro: newAvroDecoder,
+}
+
+// newRecordDecoder returns the RecordDecoder for format, configured with
+// options. An empty format defaults to FormatJSON for backwards
+// compatibility with definitions created before Format existed.
+func newRecordDecoder(format string, options map[string]string) (RecordDecoder, error) {
+	if format == "" {
+		format = FormatJSON
+	}
+	factory, ok := recordDecoderFactories[format]
+	if !ok {
+		return nil, fmt.Errorf("unsupported input format: %s", format)
+	}
+	return factory(options)
+}
+
+// ContentTypeFormat maps the Content-Type of a request against
+// /index/<i>/input/<name> to an InputDefinition Format, so the handler can
+// accept any registered format transparently.
+func ContentTypeFormat(contentType string) (string, error) {
+	switch contentType {
+	case "application/json", "application/x-ndjson":
+		return FormatJSON, nil
+	case "text/csv":
+		return FormatCSV, nil
+	case "avro/binary":
+		return FormatAvro, nil
+	default:
+		return "", fmt.Errorf("unsupported content type: %s", contentType)
+	}
+}
+
+// DecodeInputRequest is meant to be called from the body of an HTTP
+// /index/<i>/input/<name> handler (not implemented in this package): it
+// derives the definition's configured decoder, falling back to the
+// request's Content-Type if the definition hasn't been given an explicit
+// Format, and decodes r into the channel pair InputDefinition's callers
+// already know how to consume.
+func DecodeInputRequest(i *InputDefinition, contentType string, r io.Reader) (<-chan map[string]interface{}, <-chan error, error) {
+	format := i.Format()
+	decoder := i.Decoder()
+	if format == "" {
+		f, err := ContentTypeFormat(contentType)
+		if err != nil {
+			return nil, nil, err
+		}
+		format, decoder = f, nil
+	}
+	if decoder == nil {
+		var err error
+		decoder, err = newRecordDecoder(format, i.FormatOptions())
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	records, errs := decoder.Decode(r, i.Fields())
+	return records, errs, nil
+}
+
+// fieldNameSet returns the set of field names a decoder should accept,
+// keyed for quick membership checks.
+func fieldNameSet(fields []Field) map[string]bool {
+	names := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		names[f.Name] = true
+	}
+	return names
+}
+
+// validateRecordFields reports an error naming the first key in record that
+// isn't one of the definition's known fields, e.g. to catch a typo'd CSV
+// header or JSON key before it silently falls through every action handler
+// unmatched.
+func validateRecordFields(record map[string]interface{}, names map[string]bool) error {
+	for key := range record {
+		if !names[key] {
+			return fmt.Errorf("record has field %q not present in the input definition", key)
+		}
+	}
+	return nil
+}
+
+// jsonLinesDecoder implements RecordDecoder for newline-delimited JSON
+// objects, one per record.
+type jsonLinesDecoder struct{}
+
+func (jsonLinesDecoder) Decode(r io.Reader, fields []Field) (<-chan map[string]interface{}, <-chan error) {
+	records := make(chan map[string]interface{})
+	errs := make(chan error, 1)
+	names := fieldNameSet(fields)
+
+	go func() {
+		defer close(records)
+		defer close(errs)
+
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := scanner.Bytes()
+			if len(line) == 0 {
+				continue
+			}
+			var record map[string]interface{}
+			if err := json.Unmarshal(line, &record); err != nil {
+				errs <- err
+				continue
+			}
+			if err := validateRecordFields(record, names); err != nil {
+				errs <- err
+				continue
+			}
+			records <- record
+		}
+		if err := scanner.Err(); err != nil {
+			errs <- err
+		}
+	}()
+
+	return records, errs
+}
+
+// csvDecoder implements RecordDecoder for CSV, mapping the header row to
+// Field.Name and every subsequent row to a record. The delimiter is
+// configurable via the "delimiter" option; the quote character is not, since
+// encoding/csv's Reader always uses '"' and exposes no way to change it.
+type csvDecoder struct {
+	delimiter rune
+}
+
+func newCSVDecoder(options map[string]string) (RecordDecoder, error) {
+	d := csvDecoder{delimiter: ','}
+	if v, ok := options["delimiter"]; ok {
+		if len(v) != 1 {
+			return nil, fmt.Errorf("csv delimiter must be a single character, got %q", v)
+		}
+		d.delimiter = rune(v[0])
+	}
+	// encoding/csv's Reader hardcodes '"' as its quote character and has no
+	// way to override it, so a "quote" option asking for anything else is
+	// rejected outright rather than silently ignored.
+	if v, ok := options["quote"]; ok && v != `"` {
+		return nil, fmt.Errorf(`csv quote character is fixed at '"' (encoding/csv does not support a custom quote), got %q`, v)
+	}
+	return d, nil
+}
+
+func (d csvDecoder) Decode(r io.Reader, fields []Field) (<-chan map[string]interface{}, <-chan error) {
+	records := make(chan map[string]interface{})
+	errs := make(chan error, 1)
+	names := fieldNameSet(fields)
+
+	go func() {
+		defer close(records)
+		defer close(errs)
+
+		cr := csv.NewReader(r)
+		cr.Comma = d.delimiter
+		cr.FieldsPerRecord = -1
+
+		header, err := cr.Read()
+		if err != nil {
+			if err != io.EOF {
+				errs <- err
+			}
+			return
+		}
+		for _, name := range header {
+			if !names[name] {
+				errs <- fmt.Errorf("csv header %q does not match any field in the input definition", name)
+				return
+			}
+		}
+
+		for {
+			row, err := cr.Read()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				errs <- err
+				continue
+			}
+
+			record := make(map[string]interface{}, len(header))
+			for idx, name := range header {
+				if idx >= len(row) {
+					continue
+				}
+				record[name] = row[idx]
+			}
+			records <- record
+		}
+	}()
+
+	return records, errs
+}
+
+// avroCodec is the subset of github.com/linkedin/goavro's Codec this
+// package needs. Decoding an Avro stream is delegated to whatever codec
+// implementation is registered via RegisterAvroCodecFactory, so this
+// package itself takes no dependency on a specific Avro library.
+type avroCodec interface {
+	Decode(r io.Reader) (interface{}, error)
+}
+
+// AvroCodecFactory builds an avroCodec for the given Avro schema. This
+// package ships no Avro implementation of its own: the default factory
+// always fails, so FormatAvro is unusable until a binary that wants Avro
+// input support registers a real implementation (e.g. backed by goavro) at
+// init time via RegisterAvroCodecFactory.
+type AvroCodecFactory func(schema string) (avroCodec, error)
+
+var avroCodecFactory AvroCodecFactory = func(schema string) (avroCodec, error) {
+	return nil, fmt.Errorf("avro format is not available: no AvroCodecFactory registered, see RegisterAvroCodecFactory")
+}
+
+// RegisterAvroCodecFactory installs f as the codec used to decode Avro
+// input streams. It's expected to be called from an init function in a
+// package that imports a concrete Avro library, keeping that dependency out
+// of pilosa's core package graph.
+func RegisterAvroCodecFactory(f AvroCodecFactory) {
+	avroCodecFactory = f
+}
+
+// avroDecoder implements RecordDecoder for Avro-encoded records, using
+// either an embedded schema or one fetched from a schema-registry URL.
+type avroDecoder struct {
+	schema      string
+	registryURL string
+}
+
+func newAvroDecoder(options map[string]string) (RecordDecoder, error) {
+	d := avroDecoder{
+		schema:      options["schema"],
+		registryURL: options["schemaRegistryURL"],
+	}
+	if d.schema == "" && d.registryURL == "" {
+		return nil, fmt.Errorf("avro format requires either a schema or a schemaRegistryURL option")
+	}
+	return d, nil
+}
+
+func (d avroDecoder) Decode(r io.Reader, fields []Field) (<-chan map[string]interface{}, <-chan error) {
+	records := make(chan map[string]interface{})
+	errs := make(chan error, 1)
+	names := fieldNameSet(fields)
+
+	go func() {
+		defer close(records)
+		defer close(errs)
+
+		schema, err := d.resolveSchema()
+		if err != nil {
+			errs <- err
+			return
+		}
+
+		codec, err := avroCodecFactory(schema)
+		if err != nil {
+			errs <- err
+			return
+		}
+
+		if err := decodeAvroRecords(r, codec, names, records); err != nil && err != io.EOF {
+			errs <- err
+		}
+	}()
+
+	return records, errs
+}
+
+// resolveSchema returns the embedded schema, fetching it from the
+// configured schema-registry URL if one wasn't provided directly.
+func (d avroDecoder) resolveSchema() (string, error) {
+	if d.schema != "" {
+		return d.schema, nil
+	}
+
+	resp, err := http.Get(d.registryURL)
+	if err != nil {
+		return "", fmt.Errorf("fetching avro schema from %s: %v", d.registryURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching avro schema from %s: status %s", d.registryURL, resp.Status)
+	}
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// decodeAvroRecords decodes Avro binary records from r using codec, sending
+// each as a generic record on out.
+func decodeAvroRecords(r io.Reader, codec avroCodec, names map[string]bool, out chan<- map[string]interface{}) error {
+	br := bufio.NewReader(r)
+	for {
+		native, err := codec.Decode(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		record, ok := native.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("avro record is not an object: %T", native)
+		}
+		if err := validateRecordFields(record, names); err != nil {
+			return err
+		}
+		out <- record
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2017 Pilosa Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilosa
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCSVDecoder_RejectsUnknownHeader(t *testing.T) {
+	decoder, err := newCSVDecoder(nil)
+	if err != nil {
+		t.Fatalf("unexpected error building decoder: %v", err)
+	}
+
+	fields := []Field{{Name: "id"}, {Name: "color"}}
+	r := strings.NewReader("id,colour\n1,red\n")
+
+	records, errs := decoder.Decode(r, fields)
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatal("expected an error for the typo'd header")
+		}
+	case rec, ok := <-records:
+		if ok {
+			t.Fatalf("expected no records to be decoded, got %v", rec)
+		}
+	}
+}
+
+func TestCSVDecoder_AcceptsKnownHeader(t *testing.T) {
+	decoder, err := newCSVDecoder(nil)
+	if err != nil {
+		t.Fatalf("unexpected error building decoder: %v", err)
+	}
+
+	fields := []Field{{Name: "id"}, {Name: "color"}}
+	r := strings.NewReader("id,color\n1,red\n2,blue\n")
+
+	records, errs := decoder.Decode(r, fields)
+	var got []map[string]interface{}
+	for rec := range records {
+		got = append(got, rec)
+	}
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected decode error: %v", err)
+		}
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 records, got %d: %v", len(got), got)
+	}
+	if got[0]["color"] != "red" || got[1]["color"] != "blue" {
+		t.Fatalf("unexpected records: %v", got)
+	}
+}
+
+func TestNewCSVDecoder_RejectsUnsupportedQuote(t *testing.T) {
+	if _, err := newCSVDecoder(map[string]string{"quote": "'"}); err == nil {
+		t.Fatal("expected an error for a quote character other than the fixed '\"'")
+	}
+	if _, err := newCSVDecoder(map[string]string{"quote": `"`}); err != nil {
+		t.Fatalf("unexpected error for the default quote character: %v", err)
+	}
+}
+
+func TestJSONLinesDecoder_RejectsUnknownField(t *testing.T) {
+	fields := []Field{{Name: "id"}}
+	r := strings.NewReader(`{"id": 1, "extra": "oops"}` + "\n")
+
+	records, errs := jsonLinesDecoder{}.Decode(r, fields)
+	sawErr := false
+	sawRec := false
+	for records != nil || errs != nil {
+		select {
+		case _, ok := <-records:
+			if !ok {
+				records = nil
+				continue
+			}
+			sawRec = true
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			if err != nil {
+				sawErr = true
+			}
+		}
+	}
+	if !sawErr {
+		t.Fatal("expected an error for the record with an unknown field")
+	}
+	if sawRec {
+		t.Fatal("expected the invalid record not to be emitted")
+	}
+}
+
+func TestNewAvroDecoder_RequiresSchemaOrRegistry(t *testing.T) {
+	if _, err := newAvroDecoder(nil); err == nil {
+		t.Fatal("expected an error when neither schema nor schemaRegistryURL is set")
+	}
+}
+
+func TestAvroDecoder_FailsWithoutRegisteredCodecFactory(t *testing.T) {
+	decoder, err := newAvroDecoder(map[string]string{"schema": `{"type":"record","name":"r","fields":[]}`})
+	if err != nil {
+		t.Fatalf("unexpected error building decoder: %v", err)
+	}
+
+	_, errs := decoder.Decode(strings.NewReader(""), nil)
+	err = <-errs
+	if err == nil {
+		t.Fatal("expected an error since no AvroCodecFactory is registered by default")
+	}
+}
+
+func TestDecodeInputRequest_FallsBackToContentType(t *testing.T) {
+	def := &InputDefinition{}
+	def.fields = []Field{{Name: "id"}}
+
+	records, errs, err := DecodeInputRequest(def, "application/json", strings.NewReader(`{"id": 1}`+"\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got []map[string]interface{}
+	for rec := range records {
+		got = append(got, rec)
+	}
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected decode error: %v", err)
+		}
+	}
+	if len(got) != 1 || got[0]["id"].(float64) != 1 {
+		t.Fatalf("unexpected records: %v", got)
+	}
+}
+
+func TestDecodeInputRequest_UsesConfiguredDecoderOverContentType(t *testing.T) {
+	def := &InputDefinition{}
+	def.fields = []Field{{Name: "id"}}
+	def.format = FormatCSV
+	decoder, err := newRecordDecoder(def.format, nil)
+	if err != nil {
+		t.Fatalf("unexpected error building decoder: %v", err)
+	}
+	def.decoder = decoder
+
+	// Even though the request claims JSON, the definition's own configured
+	// decoder wins.
+	records, errs, err := DecodeInputRequest(def, "application/json", strings.NewReader("id\n1\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got []map[string]interface{}
+	for rec := range records {
+		got = append(got, rec)
+	}
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected decode error: %v", err)
+		}
+	}
+	if len(got) != 1 || got[0]["id"] != "1" {
+		t.Fatalf("unexpected records: %v", got)
+	}
+}
+
+func TestContentTypeFormat(t *testing.T) {
+	cases := map[string]string{
+		"application/json":     FormatJSON,
+		"application/x-ndjson": FormatJSON,
+		"text/csv":             FormatCSV,
+		"avro/binary":          FormatAvro,
+	}
+	for contentType, want := range cases {
+		got, err := ContentTypeFormat(contentType)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", contentType, err)
+		}
+		if got != want {
+			t.Fatalf("ContentTypeFormat(%q) = %q, want %q", contentType, got, want)
+		}
+	}
+
+	if _, err := ContentTypeFormat("application/xml"); err == nil {
+		t.Fatal("expected an error for an unsupported content type")
+	}
+}